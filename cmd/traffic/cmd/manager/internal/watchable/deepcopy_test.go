@@ -0,0 +1,54 @@
+package watchable_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/watchable"
+)
+
+// TestDeepCopyNested verifies that values with nested reference types (slices and maps) are never
+// shared between the map and its callers.
+func TestDeepCopyNested(t *testing.T) {
+	var m watchable.AgentMap
+
+	a := &manager.AgentInfo{
+		Name:        "a",
+		Mechanisms:  []*manager.AgentInfo_Mechanism{{Name: "tcp"}},
+		Environment: map[string]string{"A": "a"},
+	}
+	m.Store("k", a)
+
+	// Check that mutating the stored value doesn't affect the map
+	a.Mechanisms[0].Name = "mutated"
+	a.Mechanisms = append(a.Mechanisms, &manager.AgentInfo_Mechanism{Name: "appended"})
+	a.Environment["B"] = "b"
+
+	b, ok := m.Load("k")
+	assert.True(t, ok)
+	assert.Len(t, b.Mechanisms, 1)
+	assert.Equal(t, "tcp", b.Mechanisms[0].Name)
+	assert.Equal(t, map[string]string{"A": "a"}, b.Environment)
+
+	// Check that mutating a loaded value doesn't affect the map
+	b.Mechanisms[0].Name = "mutated"
+	b.Mechanisms = append(b.Mechanisms, &manager.AgentInfo_Mechanism{Name: "appended"})
+	b.Environment["B"] = "b"
+
+	c, ok := m.Load("k")
+	assert.True(t, ok)
+	assert.Len(t, c.Mechanisms, 1)
+	assert.Equal(t, "tcp", c.Mechanisms[0].Name)
+	assert.Equal(t, map[string]string{"A": "a"}, c.Environment)
+
+	// Check the same for LoadAll
+	all := m.LoadAll()
+	all["k"].Mechanisms[0].Name = "mutated"
+	all["k"].Environment["B"] = "b"
+
+	d, ok := m.Load("k")
+	assert.True(t, ok)
+	assert.Equal(t, "tcp", d.Mechanisms[0].Name)
+	assert.Equal(t, map[string]string{"A": "a"}, d.Environment)
+}
@@ -3,10 +3,17 @@
 package watchable
 
 import (
+    "bytes"
     "context"
+    "encoding/json"
+    "fmt"
+    "sort"
     "sync"
+    "time"
 
     "github.com/telepresenceio/telepresence/rpc/v2/manager"
+    "github.com/datawire/dlib/dlog"
+    "google.golang.org/protobuf/encoding/protojson"
     "google.golang.org/protobuf/proto"
 )
 
@@ -36,7 +43,11 @@ type AgentMapSnapshot struct {
 // 2. it provides type safety (compared to a sync.Map)
 // 3. it provides a compare-and-swap operation
 // 4. you can Subscribe to either the whole map or just a subset of the map to watch for updates.
-//    This gives you complete snapshots, deltas, and coalescing of rapid updates.
+//    This gives you complete snapshots, deltas, and coalescing of rapid updates.  The predicate of
+//    a subset subscription can be replaced with SetSubsetFilter, and any snapshot subscription can
+//    be ended early with Unsubscribe.
+// 5. you can SubscribeDeltas to instead receive one coalesced update per changed key, without
+//    complete snapshots.
 type AgentMap struct {
     lock sync.RWMutex
     // things guarded by 'lock'
@@ -44,6 +55,9 @@ type AgentMap struct {
     value       map[string]*manager.AgentInfo
     subscribers map[<-chan AgentMapUpdate]chan<- AgentMapUpdate // readEnd ↦ writeEnd
 
+    snapshotSubscribers map[<-chan AgentMapSnapshot]<-chan AgentMapUpdate            // downstream ↦ upstream
+    subsetFilters       map[<-chan AgentMapSnapshot]chan func(string, *manager.AgentInfo) bool // downstream ↦ pending predicate
+
     // not guarded by 'lock'
     wg sync.WaitGroup
 }
@@ -53,6 +67,8 @@ func (tm *AgentMap) unlockedInit() {
 	tm.close = make(chan struct{})
 	tm.value = make(map[string]*manager.AgentInfo)
 	tm.subscribers = make(map[<-chan AgentMapUpdate]chan<- AgentMapUpdate)
+	tm.snapshotSubscribers = make(map[<-chan AgentMapSnapshot]<-chan AgentMapUpdate)
+	tm.subsetFilters = make(map[<-chan AgentMapSnapshot]chan func(string, *manager.AgentInfo) bool)
     }
 }
 
@@ -81,14 +97,16 @@ func (tm *AgentMap) LoadAll() map[string]*manager.AgentInfo {
 }
 
 // LoadAllMatching returns a deepcopy of all key/value pairs in the map for which the given
-// function returns true. The map is locked during the evaluation of the filter.
+// function returns true. The map is locked during the evaluation of the filter.  The filter is
+// passed deepcopies, so it can't corrupt the values in the map.
 func (tm *AgentMap) LoadAllMatching(filter func(string, *manager.AgentInfo) bool) map[string]*manager.AgentInfo {
     tm.lock.RLock()
     defer tm.lock.RUnlock()
     ret := make(map[string]*manager.AgentInfo)
     for k, v := range tm.value {
+	v = proto.Clone(v).(*manager.AgentInfo)
 	if filter(k, v) {
-	    ret[k] = proto.Clone(v).(*manager.AgentInfo)
+	    ret[k] = v
 	}
     }
     return ret
@@ -105,8 +123,9 @@ func (tm *AgentMap) Load(key string) (value *manager.AgentInfo, ok bool) {
     return proto.Clone(ret).(*manager.AgentInfo), true
 }
 
-// Store sets a key sets the value for a key.  This blocks forever if .Close() has already been
-// called.
+// Store sets a key sets the value for a key.  A deepcopy of the value is stored, so the caller may
+// continue to mutate the value without affecting the map.  This is a no-op if .Close() has already
+// been called.
 func (tm *AgentMap) Store(key string, val *manager.AgentInfo) {
     tm.lock.Lock()
     defer tm.lock.Unlock()
@@ -117,7 +136,8 @@ func (tm *AgentMap) Store(key string, val *manager.AgentInfo) {
 // LoadOrStore returns the existing value for the key if present.  Otherwise, it stores and returns
 // the given value. The 'loaded' result is true if the value was loaded, false if stored.
 //
-// If the value does need to be stored, all the same blocking semantics as .Store() apply
+// If .Close() has already been called and the key isn't present, then nothing is stored, and nil
+// and false are returned.
 func (tm *AgentMap) LoadOrStore(key string, val *manager.AgentInfo) (value *manager.AgentInfo, loaded bool) {
     tm.lock.Lock()
     defer tm.lock.Unlock()
@@ -126,7 +146,9 @@ func (tm *AgentMap) LoadOrStore(key string, val *manager.AgentInfo) (value *mana
     if loadedOK {
 	return proto.Clone(loadedVal).(*manager.AgentInfo), true
     }
-    tm.unlockedStore(key, val)
+    if !tm.unlockedStore(key, val) {
+	return nil, false
+    }
     return proto.Clone(val).(*manager.AgentInfo), false
 }
 
@@ -137,35 +159,57 @@ func (tm *AgentMap) LoadOrStore(key string, val *manager.AgentInfo) (value *mana
 //         return true
 //     }
 //     return false
+//
+// No swap is made if .Close() has already been called.
 func (tm *AgentMap) CompareAndSwap(key string, old, new *manager.AgentInfo) bool {
     tm.lock.Lock()
     defer tm.lock.Unlock()
 
     if loadedVal, loadedOK := tm.value[key]; loadedOK && proto.Equal(loadedVal, old) {
-	tm.unlockedStore(key, new)
-	return true
+	return tm.unlockedStore(key, new)
     }
     return false
 }
 
-func (tm *AgentMap) unlockedStore(key string, val *manager.AgentInfo) {
+// Update atomically replaces the value for a key with the result of calling 'fn' with a deepcopy of
+// the current value, and notifies subscribers just like .Store() does.  It returns false, without
+// calling 'fn', if the key is not present.  It also returns false if .Close() has already been
+// called.
+//
+// The map is locked while 'fn' runs, so 'fn' must not block, and must not call any methods of the
+// map.  Use .CompareAndSwap() in a loop if the computation of the new value needs to block.
+func (tm *AgentMap) Update(key string, fn func(*manager.AgentInfo) *manager.AgentInfo) bool {
+    tm.lock.Lock()
+    defer tm.lock.Unlock()
+
+    loadedVal, loadedOK := tm.value[key]
+    if !loadedOK || tm.unlockedIsClosed() {
+	return false
+    }
+    return tm.unlockedStore(key, fn(proto.Clone(loadedVal).(*manager.AgentInfo)))
+}
+
+// unlockedStore stores the value for a key, and reports whether it did so; it does not if the map
+// has been closed.
+func (tm *AgentMap) unlockedStore(key string, val *manager.AgentInfo) bool {
     tm.unlockedInit()
     if tm.unlockedIsClosed() {
-	// block forever
-	tm.lock.Unlock()
-	select {}
+	return false
     }
 
-    tm.value[key] = val
+    // Store a deepcopy so that the stored value doesn't share any nested slices or maps with
+    // the value held by the caller.
+    tm.value[key] = proto.Clone(val).(*manager.AgentInfo)
     for _, subscriber := range tm.subscribers {
 	subscriber <- AgentMapUpdate{
 	    Key:   key,
 	    Value: proto.Clone(val).(*manager.AgentInfo),
 	}
     }
+    return true
 }
 
-// Delete deletes the value for a key.  This blocks forever if .Close() has already been called.
+// Delete deletes the value for a key.  This is a no-op if .Close() has already been called.
 func (tm *AgentMap) Delete(key string) {
     tm.lock.Lock()
     defer tm.lock.Unlock()
@@ -173,17 +217,14 @@ func (tm *AgentMap) Delete(key string) {
     tm.unlockedDelete(key)
 }
 
-func (tm *AgentMap) unlockedDelete(key string) {
+// unlockedDelete deletes the value for a key, and reports whether it did so; it does not if the map
+// has been closed.
+func (tm *AgentMap) unlockedDelete(key string) bool {
     tm.unlockedInit()
     if tm.unlockedIsClosed() {
-	// block forever
-	tm.lock.Unlock()
-	select {}
+	return false
     }
 
-    if tm.value == nil {
-	return
-    }
     delete(tm.value, key)
     for _, subscriber := range tm.subscribers {
 	subscriber <- AgentMapUpdate{
@@ -191,31 +232,121 @@ func (tm *AgentMap) unlockedDelete(key string) {
 	    Delete: true,
 	}
     }
+    return true
 }
 
 // LoadAndDelete deletes the value for a key, returning a deepcopy of the previous value if any.
 // The 'loaded' result reports whether the key was present.
 //
-// If the value does need to be deleted, all the same blocking semantics as .Delete() apply.
+// If .Close() has already been called, then nothing is deleted, and nil and false are returned.
 func (tm *AgentMap) LoadAndDelete(key string) (value *manager.AgentInfo, loaded bool) {
     tm.lock.Lock()
     defer tm.lock.Unlock()
 
     loadedVal, loadedOK := tm.value[key]
-    if !loadedOK {
+    if !loadedOK || !tm.unlockedDelete(key) {
 	return nil, false
     }
 
-    tm.unlockedDelete(key)
-
     return proto.Clone(loadedVal).(*manager.AgentInfo), true
 }
 
+// Snapshot serializes all key/value pairs in the map, so that they can be reloaded with .Restore(),
+// e.g. by a new traffic-manager after a restart.  The values are encoded as protobuf JSON.
+func (tm *AgentMap) Snapshot() ([]byte, error) {
+    tm.lock.RLock()
+    defer tm.lock.RUnlock()
+
+    entries := make(map[string]json.RawMessage, len(tm.value))
+    for k, v := range tm.value {
+	data, err := protojson.Marshal(v)
+	if err != nil {
+	    return nil, fmt.Errorf("unable to marshal value for key %q: %w", k, err)
+	}
+	entries[k] = data
+    }
+    return json.Marshal(entries)
+}
+
+// Restore stores all key/value pairs from data produced by .Snapshot().  Entries that are not in
+// the snapshot are left untouched, and nothing is stored if any part of the data can't be decoded.
+// Restored entries are delivered to subscribers just like calls to .Store(), so restoring before
+// the first call to .Subscribe() makes them part of each subscriber's initial snapshot.
+//
+// This is a no-op if .Close() has already been called.
+func (tm *AgentMap) Restore(data []byte) error {
+    var entries map[string]json.RawMessage
+    if err := json.Unmarshal(data, &entries); err != nil {
+	return fmt.Errorf("unable to unmarshal snapshot: %w", err)
+    }
+    values := make(map[string]*manager.AgentInfo, len(entries))
+    for k, raw := range entries {
+	v := &manager.AgentInfo{}
+	if err := protojson.Unmarshal(raw, v); err != nil {
+	    return fmt.Errorf("unable to unmarshal value for key %q: %w", k, err)
+	}
+	values[k] = v
+    }
+
+    tm.lock.Lock()
+    defer tm.lock.Unlock()
+    for k, v := range values {
+	tm.unlockedStore(k, v)
+    }
+    return nil
+}
+
+// Checkpoint calls .Snapshot() every 'interval', and passes the result to 'sink', e.g. a function
+// that writes it to a ConfigMap or to a file on an emptyDir.  The sink isn't called again until the
+// snapshot has changed since the last successful call.  Errors from .Snapshot() or from 'sink' are
+// logged, and retried on the next tick.
+//
+// Checkpoint blocks until the Context is Done or .Close() is called.  When the map is closed, a
+// final checkpoint is made before returning, since the map can't change after that.  The
+// 'interval' must be positive.
+func (tm *AgentMap) Checkpoint(ctx context.Context, interval time.Duration, sink func([]byte) error) {
+    tm.lock.Lock()
+    tm.unlockedInit()
+    closeCh := tm.close
+    tm.lock.Unlock()
+
+    var last []byte
+    checkpoint := func() {
+	data, err := tm.Snapshot()
+	if err != nil {
+	    dlog.Errorf(ctx, "watchable.AgentMap: checkpoint failed: %v", err)
+	    return
+	}
+	if bytes.Equal(last, data) {
+	    return
+	}
+	if err = sink(data); err != nil {
+	    dlog.Errorf(ctx, "watchable.AgentMap: checkpoint failed: %v", err)
+	    return
+	}
+	last = data
+    }
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+	select {
+	case <-ctx.Done():
+	    return
+	case <-closeCh:
+	    checkpoint()
+	    return
+	case <-ticker.C:
+	    checkpoint()
+	}
+    }
+}
+
 // Close marks the map as "finished", all subscriber channels are closed and further mutations are
 // forbidden.
 //
-// After .Close() is called, any calls to .Store() will block forever, and any calls to .Subscribe()
-// will return an already-closed channel.
+// After .Close() is called, any calls to .Store(), .Delete(), or any of the other mutating methods
+// are no-ops, and any calls to .Subscribe() will return an already-closed channel.
 //
 // .Load() and .LoadAll() calls will continue to work normally after .Close() has been called.
 func (tm *AgentMap) Close() {
@@ -229,9 +360,31 @@ func (tm *AgentMap) Close() {
     tm.wg.Wait()
 }
 
+// IsClosed returns whether .Close() has been called.
+func (tm *AgentMap) IsClosed() bool {
+    tm.lock.RLock()
+    defer tm.lock.RUnlock()
+    return tm.unlockedIsClosed()
+}
+
+// SubscriberCount returns the number of active subscriptions to the map.  Subscriptions are
+// removed asynchronously when they end, so a subscription may still be counted for a short while
+// after its Context is Done.
+func (tm *AgentMap) SubscriberCount() int {
+    tm.lock.RLock()
+    defer tm.lock.RUnlock()
+    return len(tm.subscribers)
+}
+
 // internalSubscribe returns a channel (that blocks on both ends), that is written to on each map
-// update.  If the map is already Close()ed, then this returns nil.
-func (tm *AgentMap) internalSubscribe(ctx context.Context) (<-chan AgentMapUpdate, map[string]*manager.AgentInfo) {
+// update, together with the current contents of the map.  If the map is already Close()ed, then
+// this returns nil.
+//
+// If 'register' is non-nil, then it is called with the new channel before the lock is released.
+// Any other bookkeeping for the subscription must be done there; once the lock is released, a
+// .Store() may grab it and then block (while holding it) until the caller has started reading
+// from the channel.
+func (tm *AgentMap) internalSubscribe(ctx context.Context, register func(<-chan AgentMapUpdate)) (<-chan AgentMapUpdate, map[string]*manager.AgentInfo) {
     tm.lock.Lock()
     defer tm.lock.Unlock()
     tm.unlockedInit()
@@ -241,6 +394,9 @@ func (tm *AgentMap) internalSubscribe(ctx context.Context) (<-chan AgentMapUpdat
 	return nil, nil
     }
     tm.subscribers[ret] = ret
+    if register != nil {
+	register(ret)
+    }
     return ret, tm.unlockedLoadAll()
 }
 
@@ -256,41 +412,94 @@ func (tm *AgentMap) internalSubscribe(ctx context.Context) (<-chan AgentMapUpdat
 //
 // The returned channel will be closed when the Context is Done, or .Close() is called.  If .Close()
 // has already been called, then an already-closed channel is returned.
+//
+// Callers that only need a one-time consistent snapshot should use .LoadAll() or
+// .LoadAllMatching() instead.  They return the same deepcopies synchronously, without registering
+// a subscriber.
 func (tm *AgentMap) Subscribe(ctx context.Context) <-chan AgentMapSnapshot {
     return tm.SubscribeSubset(ctx, func(string, *manager.AgentInfo) bool {
 	return true
     })
 }
 
+// SubscribeWithWatchdog is like Subscribe, but also installs the given Watchdog on the
+// subscription.  See SubscribeSubsetWithWatchdog.
+func (tm *AgentMap) SubscribeWithWatchdog(ctx context.Context, watchdog Watchdog) <-chan AgentMapSnapshot {
+    return tm.SubscribeSubsetWithWatchdog(ctx, func(string, *manager.AgentInfo) bool {
+	return true
+    }, watchdog)
+}
+
 // SubscribeSubset is like Subscribe, but the snapshot returned only includes entries that satisfy
 // the 'include' predicate.  Mutations to entries that don't satisfy the predicate do not cause a
 // new snapshot to be emitted.  If the value for a key changes from satisfying the predicate to not
 // satisfying it, then this is treated as a delete operation, and a new snapshot is generated.
 func (tm *AgentMap) SubscribeSubset(ctx context.Context, include func(string, *manager.AgentInfo) bool) <-chan AgentMapSnapshot {
-    upstream, initialSnapshot := tm.internalSubscribe(ctx)
+    return tm.SubscribeSubsetWithWatchdog(ctx, include, Watchdog{})
+}
+
+// SubscribeSubsetWithWatchdog is like SubscribeSubset, but also installs the given Watchdog on the
+// subscription.  The watchdog logs a warning when a snapshot has been left unread for longer than
+// its Timeout, and if Unsubscribe is set, it also ends the subscription so that the returned
+// channel gets closed.  A zero Timeout disables the watchdog.
+func (tm *AgentMap) SubscribeSubsetWithWatchdog(
+    ctx context.Context,
+    include func(string, *manager.AgentInfo) bool,
+    watchdog Watchdog,
+) <-chan AgentMapSnapshot {
     downstream := make(chan AgentMapSnapshot)
+    // Buffered so that .SetSubsetFilter() never has to wait for the coalesce goroutine.
+    filterCh := make(chan func(string, *manager.AgentInfo) bool, 1)
 
+    upstream, initialSnapshot := tm.internalSubscribe(ctx, func(ch <-chan AgentMapUpdate) {
+	tm.snapshotSubscribers[downstream] = ch
+	tm.subsetFilters[downstream] = filterCh
+    })
     if upstream == nil {
 	close(downstream)
 	return downstream
     }
 
     tm.wg.Add(1)
-    go tm.coalesce(ctx, include, upstream, downstream, initialSnapshot)
+    go tm.coalesce(ctx, include, watchdog, upstream, downstream, filterCh, initialSnapshot)
 
     return downstream
 }
 
-func (tm *AgentMap) coalesce(
-    ctx context.Context,
-    includep func(string, *manager.AgentInfo) bool,
-    upstream <-chan AgentMapUpdate,
-    downstream chan<- AgentMapSnapshot,
-    initialSnapshot map[string]*manager.AgentInfo,
-) {
-    defer tm.wg.Done()
-    defer close(downstream)
+// SubscribeDeltas is like Subscribe, but instead of complete snapshots, the returned channel emits
+// one AgentMapUpdate for each key that has changed.  The current contents of the map are emitted
+// as a series of updates (ordered by key) immediately after the call to SubscribeDeltas().
+//
+// Updates are coalesced per key; if a key is changed several times between reads, then only the
+// latest change to that key is emitted, and changes that cancel each other out (e.g. setting a
+// key and then deleting it again) are not emitted at all.  Updates that delete a value have
+// .Delete=true, and .Value set to the value that was deleted.  A read from the channel will block
+// as long as there are no changes since the last read.
+//
+// The values in the updates are deepcopies of the actual values in the map, but the same value may
+// be used when comparing subsequent updates; if you mutate a value in an update, that mutation
+// may cause later updates to be erroneously dropped.
+//
+// The returned channel will be closed when the Context is Done, or .Close() is called.  If .Close()
+// has already been called, then an already-closed channel is returned.
+func (tm *AgentMap) SubscribeDeltas(ctx context.Context) <-chan AgentMapUpdate {
+    upstream, initialSnapshot := tm.internalSubscribe(ctx, nil)
+    downstream := make(chan AgentMapUpdate)
+
+    if upstream == nil {
+	close(downstream)
+	return downstream
+    }
+
+    tm.wg.Add(1)
+    go tm.coalesceDeltas(ctx, upstream, downstream, initialSnapshot)
 
+    return downstream
+}
+
+// unsubscriber returns a function that removes the subscription 'upstream' from the map, which
+// will cause 'upstream' to be closed.  Only the first call to the returned function has any effect.
+func (tm *AgentMap) unsubscriber(upstream <-chan AgentMapUpdate) func() {
     var shutdown func()
     shutdown = func() {
 	shutdown = func() {} // Make this function an empty one after first run to prevent calling the following goroutine multiple times
@@ -301,10 +510,95 @@ func (tm *AgentMap) coalesce(
 	go func() {
 	    tm.lock.Lock()
 	    defer tm.lock.Unlock()
-	    close(tm.subscribers[upstream])
-	    delete(tm.subscribers, upstream)
+	    tm.unlockedUnsubscribe(upstream)
 	}()
     }
+    return func() { shutdown() }
+}
+
+// unlockedUnsubscribe closes and removes the subscription 'upstream', unless that has already
+// been done.  It reports whether the subscription was found.
+func (tm *AgentMap) unlockedUnsubscribe(upstream <-chan AgentMapUpdate) bool {
+    writeEnd, ok := tm.subscribers[upstream]
+    if ok {
+	close(writeEnd)
+	delete(tm.subscribers, upstream)
+    }
+    return ok
+}
+
+// Unsubscribe ends the subscription that returned the channel 'ch' from Subscribe, SubscribeSubset,
+// or one of their WithWatchdog variants, just as if its Context had been canceled.  The channel is
+// closed asynchronously, so a snapshot that was already pending may still be read from it before
+// it's closed.  It reports whether 'ch' was an active subscription of this map.
+func (tm *AgentMap) Unsubscribe(ch <-chan AgentMapSnapshot) bool {
+    tm.lock.Lock()
+    defer tm.lock.Unlock()
+
+    upstream, ok := tm.snapshotSubscribers[ch]
+    if !ok {
+	return false
+    }
+    delete(tm.snapshotSubscribers, ch)
+    delete(tm.subsetFilters, ch)
+    return tm.unlockedUnsubscribe(upstream)
+}
+
+// SetSubsetFilter replaces the 'include' predicate of the subscription that returned the channel
+// 'ch' from Subscribe, SubscribeSubset, or one of their WithWatchdog variants.  The current contents
+// of the map are re-evaluated against the new predicate; entries that no longer satisfy it are
+// treated as delete operations, entries that now satisfy it are treated as store operations, and
+// the resulting snapshot is emitted on 'ch'.  If the new predicate doesn't change which entries
+// are included, then no new snapshot is emitted.
+//
+// The predicate is swapped asynchronously, but atomically with respect to the stream of updates;
+// every snapshot read from 'ch' has been filtered by exactly one predicate.  If SetSubsetFilter is
+// called several times in quick succession, then only the last predicate may take effect.  It
+// reports whether 'ch' was an active subscription of this map.
+func (tm *AgentMap) SetSubsetFilter(ch <-chan AgentMapSnapshot, include func(string, *manager.AgentInfo) bool) bool {
+    tm.lock.Lock()
+    defer tm.lock.Unlock()
+
+    filterCh, ok := tm.subsetFilters[ch]
+    if !ok {
+	return false
+    }
+    // Only writers hold the lock, so after discarding a predicate that the coalesce goroutine
+    // hasn't picked up yet, there's guaranteed to be room in the buffer.
+    select {
+    case <-filterCh:
+    default:
+    }
+    filterCh <- include
+    return true
+}
+
+func (tm *AgentMap) coalesce(
+    ctx context.Context,
+    includep func(string, *manager.AgentInfo) bool,
+    watchdog Watchdog,
+    upstream <-chan AgentMapUpdate,
+    downstream chan AgentMapSnapshot, // bidirectional because it's also a key in tm.snapshotSubscribers
+    filterCh <-chan func(string, *manager.AgentInfo) bool,
+    initialSnapshot map[string]*manager.AgentInfo,
+) {
+    defer tm.wg.Done()
+    defer close(downstream)
+    defer func() {
+	tm.lock.Lock()
+	delete(tm.snapshotSubscribers, downstream)
+	delete(tm.subsetFilters, downstream)
+	tm.lock.Unlock()
+    }()
+
+    shutdown := tm.unsubscriber(upstream)
+
+    // All is the current state of the map according to all AgentMapUpdates we've received from
+    // 'upstream'.  We need to keep it around so that we can re-evaluate it if 'includep' changes.
+    all := make(map[string]*manager.AgentInfo, len(initialSnapshot))
+    for k, v := range initialSnapshot {
+	all[k] = v
+    }
 
     // Cur is a snapshot of the current state all the map according to all AgentMapUpdates we've
     // received from 'upstream', with any entries removed that do not satisfy the predicate
@@ -333,6 +627,11 @@ func (tm *AgentMap) coalesce(
 
     // applyUpdate applies an update to 'cur', and updates 'snapshot.State' as nescessary.
     applyUpdate := func(update AgentMapUpdate) {
+	if update.Delete {
+	    delete(all, update.Key)
+	} else {
+	    all[update.Key] = update.Value
+	}
 	if update.Delete || !includep(update.Key, update.Value) {
 	    if old, haveOld := cur[update.Key]; haveOld {
 		update.Delete = true
@@ -364,6 +663,20 @@ func (tm *AgentMap) coalesce(
 	}
     }
 
+    // applyFilter replaces 'includep', and then re-applies every entry in 'all' (ordered by key) so
+    // that 'cur' and 'snapshot' match the new predicate.
+    applyFilter := func(include func(string, *manager.AgentInfo) bool) {
+	includep = include
+	keys := make([]string, 0, len(all))
+	for k := range all {
+	    keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+	    applyUpdate(AgentMapUpdate{Key: k, Value: all[k]})
+	}
+    }
+
     // The following loop is reading both a tm.close channel and the ctx.Done() channel. When the
     // tm.close channel is closed, the Map as a whole has been closed, and when ctx.Done() is closed,
     // the subscription that started this call to coalesce has ended. If one of the channels close,
@@ -371,10 +684,20 @@ func (tm *AgentMap) coalesce(
     // closed channel. The closed channel is therefore set to `nil` so that it blocks forever, which
     // in essence means that the only way out of the loop is to close the `upstream` channel. This
     // happens when the subscription ends.
+    //
+    // The watchdog timer is running while a snapshot is waiting to be read by 'downstream'.  Once
+    // the watchdog has unsubscribed, 'abandoned' is set and no more snapshots are sent.
     closeCh := tm.close
     doneCh := ctx.Done()
+    var watchdogTimer *time.Timer
+    abandoned := false
+    defer func() {
+	if watchdogTimer != nil {
+	    watchdogTimer.Stop()
+	}
+    }()
     for {
-	if snapshot.State == nil {
+	if snapshot.State == nil || abandoned {
 	    select {
 	    case <-doneCh:
 		shutdown()
@@ -387,9 +710,19 @@ func (tm *AgentMap) coalesce(
 		    return
 		}
 		applyUpdate(update)
+	    case include := <-filterCh:
+		applyFilter(include)
 	    }
 	} else {
-	    // Same as above, but with an additional "downstream <- snapshot" case.
+	    if watchdogTimer == nil && watchdog.Timeout > 0 {
+		watchdogTimer = time.NewTimer(watchdog.Timeout)
+	    }
+	    var watchdogCh <-chan time.Time
+	    if watchdogTimer != nil {
+		watchdogCh = watchdogTimer.C
+	    }
+
+	    // Same as above, but with additional "downstream <- snapshot" and watchdog cases.
 	    select {
 	    case <-doneCh:
 		shutdown()
@@ -402,8 +735,109 @@ func (tm *AgentMap) coalesce(
 		    return
 		}
 		applyUpdate(update)
+	    case include := <-filterCh:
+		applyFilter(include)
 	    case downstream <- snapshot:
 		snapshot = AgentMapSnapshot{}
+		if watchdogTimer != nil {
+		    watchdogTimer.Stop()
+		    watchdogTimer = nil
+		}
+	    case <-watchdogCh:
+		// The timer is left in place, so that this only fires once per unread snapshot.
+		if watchdog.Unsubscribe {
+		    dlog.Warnf(ctx, "watchable.AgentMap: subscriber has not read a snapshot for %v, unsubscribing", watchdog.Timeout)
+		    shutdown()
+		    abandoned = true
+		} else {
+		    dlog.Warnf(ctx, "watchable.AgentMap: subscriber has not read a snapshot for %v", watchdog.Timeout)
+		}
+	    }
+	}
+    }
+}
+
+func (tm *AgentMap) coalesceDeltas(
+    ctx context.Context,
+    upstream <-chan AgentMapUpdate,
+    downstream chan<- AgentMapUpdate,
+    initialSnapshot map[string]*manager.AgentInfo,
+) {
+    defer tm.wg.Done()
+    defer close(downstream)
+
+    shutdown := tm.unsubscriber(upstream)
+
+    // 'sent' is the state of the map as it is known to the reader of 'downstream'.
+    sent := make(map[string]*manager.AgentInfo, len(initialSnapshot))
+
+    // 'pending' holds the latest unsent update for each key, and 'order' holds the keys in the
+    // order in which they became pending.  Keys in 'order' that are no longer in 'pending' are
+    // skipped.
+    pending := make(map[string]AgentMapUpdate, len(initialSnapshot))
+    order := make([]string, 0, len(initialSnapshot))
+    for k, v := range initialSnapshot {
+	pending[k] = AgentMapUpdate{Key: k, Value: v}
+	order = append(order, k)
+    }
+    sort.Strings(order)
+
+    // applyUpdate compares an update to 'sent', and updates 'pending' and 'order' as nescessary.
+    applyUpdate := func(update AgentMapUpdate) {
+	old, haveOld := sent[update.Key]
+	if update.Delete {
+	    if !haveOld {
+		// The reader never saw this key, so there's nothing to delete.
+		delete(pending, update.Key)
+		return
+	    }
+	    update.Value = old
+	} else if haveOld && proto.Equal(old, update.Value) {
+	    // The reader already has this value.
+	    delete(pending, update.Key)
+	    return
+	}
+	if _, isPending := pending[update.Key]; !isPending {
+	    order = append(order, update.Key)
+	}
+	pending[update.Key] = update
+    }
+
+    // See coalesce() for a description of how 'closeCh' and 'doneCh' are used.
+    closeCh := tm.close
+    doneCh := ctx.Done()
+    for {
+	// Find the next update to send, if any.  Leaving 'sendCh' as nil disables the send case.
+	var sendCh chan<- AgentMapUpdate
+	var next AgentMapUpdate
+	for len(order) > 0 {
+	    if update, isPending := pending[order[0]]; isPending {
+		next = update
+		sendCh = downstream
+		break
+	    }
+	    order = order[1:]
+	}
+
+	select {
+	case <-doneCh:
+	    shutdown()
+	    doneCh = nil
+	case <-closeCh:
+	    shutdown()
+	    closeCh = nil
+	case update, readOK := <-upstream:
+	    if !readOK {
+		return
+	    }
+	    applyUpdate(update)
+	case sendCh <- next:
+	    order = order[1:]
+	    delete(pending, next.Key)
+	    if next.Delete {
+		delete(sent, next.Key)
+	    } else {
+		sent[next.Key] = next.Value
 	    }
 	}
     }
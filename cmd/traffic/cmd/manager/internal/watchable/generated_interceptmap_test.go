@@ -5,6 +5,8 @@ package watchable_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -50,7 +52,64 @@ func assertInterceptMapSnapshotEqual(t *testing.T, expected, actual watchable.In
 }
 
 func TestInterceptMap_Close(t *testing.T) {
-	// TODO
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	// Check that a zero map isn't closed
+	assert.False(t, m.IsClosed())
+
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+	ch := m.Subscribe(ctx)
+	m.Close()
+	assert.True(t, m.IsClosed())
+
+	// Check that subscriptions get closed
+	for range ch {
+	}
+
+	// Check that Store is a no-op
+	m.Store("b", &manager.InterceptInfo{Id: "B"})
+	m.Store("a", &manager.InterceptInfo{Id: "a"})
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"a": {Id: "A"},
+			},
+		},
+		watchable.InterceptMapSnapshot{State: m.LoadAll()})
+
+	// Check that Delete is a no-op
+	m.Delete("a")
+	v, ok := m.Load("a")
+	assert.True(t, ok)
+	assertDeepCopies(t, &manager.InterceptInfo{Id: "A"}, v)
+
+	// Check that LoadOrStore loads, but doesn't store
+	v, ok = m.LoadOrStore("a", &manager.InterceptInfo{Id: "a"})
+	assert.True(t, ok)
+	assertDeepCopies(t, &manager.InterceptInfo{Id: "A"}, v)
+	v, ok = m.LoadOrStore("c", &manager.InterceptInfo{Id: "C"})
+	assert.False(t, ok)
+	assert.Nil(t, v)
+	_, ok = m.Load("c")
+	assert.False(t, ok)
+
+	// Check that CompareAndSwap doesn't swap
+	assert.False(t, m.CompareAndSwap("a", &manager.InterceptInfo{Id: "A"}, &manager.InterceptInfo{Id: "a"}))
+	v, ok = m.Load("a")
+	assert.True(t, ok)
+	assertDeepCopies(t, &manager.InterceptInfo{Id: "A"}, v)
+
+	// Check that LoadAndDelete doesn't delete
+	v, ok = m.LoadAndDelete("a")
+	assert.False(t, ok)
+	assert.Nil(t, v)
+	_, ok = m.Load("a")
+	assert.True(t, ok)
+
+	// Check that a repeated Close works
+	m.Close()
+	assert.True(t, m.IsClosed())
 }
 
 func TestInterceptMap_Delete(t *testing.T) {
@@ -119,6 +178,58 @@ func TestInterceptMap_LoadAll(t *testing.T) {
 	// TODO
 }
 
+func TestInterceptMap_LoadAllMatching(t *testing.T) {
+	var m watchable.InterceptMap
+
+	// Check that a zero map works
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{State: map[string]*manager.InterceptInfo{}},
+		watchable.InterceptMapSnapshot{State: m.LoadAllMatching(func(string, *manager.InterceptInfo) bool { return true })})
+
+	a := &manager.InterceptInfo{Id: "A"}
+	m.Store("a", a)
+	m.Store("b", &manager.InterceptInfo{Id: "B"})
+	m.Store("c", &manager.InterceptInfo{Id: "ignoreme"})
+
+	// Check that only the entries that satisfy the predicate are returned
+	matching := m.LoadAllMatching(func(k string, v *manager.InterceptInfo) bool {
+		return v.Id != "ignoreme"
+	})
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"a": {Id: "A"},
+				"b": {Id: "B"},
+			},
+		},
+		watchable.InterceptMapSnapshot{State: matching})
+
+	// Check that the returned values are copies
+	assertDeepCopies(t, a, matching["a"])
+
+	// Check that the predicate also gets passed the key
+	matching = m.LoadAllMatching(func(k string, v *manager.InterceptInfo) bool {
+		return k == "c"
+	})
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"c": {Id: "ignoreme"},
+			},
+		},
+		watchable.InterceptMapSnapshot{State: matching})
+
+	// Check that a predicate that mutates its argument doesn't affect the map
+	before := m.LoadAll()
+	m.LoadAllMatching(func(_ string, v *manager.InterceptInfo) bool {
+		v.Id = "mutated"
+		return false
+	})
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{State: before},
+		watchable.InterceptMapSnapshot{State: m.LoadAll()})
+}
+
 func TestInterceptMap_LoadAndDelete(t *testing.T) {
 	var m watchable.InterceptMap
 
@@ -169,12 +280,278 @@ func TestInterceptMap_LoadOrStore(t *testing.T) {
 	assertDeepCopies(t, d, e)
 }
 
+func TestInterceptMap_SnapshotRestore(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+	m.Store("b", &manager.InterceptInfo{Id: "B"})
+	data, err := m.Snapshot()
+	assert.NoError(t, err)
+
+	// Check that a restored map contains the same entries, as copies
+	var r watchable.InterceptMap
+	r.Store("c", &manager.InterceptInfo{Id: "C"})
+	assert.NoError(t, r.Restore(data))
+	expected := watchable.InterceptMapSnapshot{
+		State: map[string]*manager.InterceptInfo{
+			"a": {Id: "A"},
+			"b": {Id: "B"},
+			"c": {Id: "C"},
+		},
+	}
+	assertInterceptMapSnapshotEqual(t, expected, watchable.InterceptMapSnapshot{State: r.LoadAll()})
+
+	// Check that the restored entries are part of the initial snapshot
+	ch := r.Subscribe(ctx)
+	snapshot, ok := <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t, expected, snapshot)
+
+	// Check that undecodable data is rejected without storing anything
+	assert.Error(t, r.Restore([]byte(`{"d": {}, "e": 1}`)))
+	assert.Error(t, r.Restore([]byte(`garbage`)))
+	assertInterceptMapSnapshotEqual(t, expected, watchable.InterceptMapSnapshot{State: r.LoadAll()})
+
+	// Check that an empty map round-trips
+	var e watchable.InterceptMap
+	data, err = e.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, r.Restore(data))
+	assertInterceptMapSnapshotEqual(t, expected, watchable.InterceptMapSnapshot{State: r.LoadAll()})
+
+	r.Close()
+}
+
+func TestInterceptMap_Checkpoint(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+
+	checkpoints := make(chan []byte, 10)
+	failNext := false
+	sink := func(data []byte) error {
+		if failNext {
+			failNext = false
+			return fmt.Errorf("unable to write checkpoint")
+		}
+		checkpoints <- data
+		return nil
+	}
+	assertNextCheckpoint := func(expected map[string]*manager.InterceptInfo) {
+		t.Helper()
+		select {
+		case data := <-checkpoints:
+			var r watchable.InterceptMap
+			assert.NoError(t, r.Restore(data))
+			assertInterceptMapSnapshotEqual(t, watchable.InterceptMapSnapshot{State: expected}, watchable.InterceptMapSnapshot{State: r.LoadAll()})
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for checkpoint")
+		}
+	}
+	assertNoCheckpoint := func() {
+		t.Helper()
+		select {
+		case data := <-checkpoints:
+			assert.Failf(t, "unexpected checkpoint", "%s", data)
+		case <-time.After(50 * time.Millisecond): // a handful of intervals
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Checkpoint(ctx, 10*time.Millisecond, sink)
+	}()
+
+	// Check that the first tick writes a checkpoint, and that an unchanged map doesn't
+	assertNextCheckpoint(map[string]*manager.InterceptInfo{
+		"a": {Id: "A"},
+	})
+	assertNoCheckpoint()
+
+	// Check that changes are written on the next tick
+	m.Store("b", &manager.InterceptInfo{Id: "B"})
+	assertNextCheckpoint(map[string]*manager.InterceptInfo{
+		"a": {Id: "A"},
+		"b": {Id: "B"},
+	})
+
+	// Check that Close makes a final checkpoint and ends the checkpointing
+	m.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Checkpoint did not return after Close")
+	}
+	assertNoCheckpoint()
+
+	// Check that a failed checkpoint is retried, and that canceling the Context ends the
+	// checkpointing.  The failure is logged as an error, so use a Context that permits that.
+	var n watchable.InterceptMap
+	n.Store("c", &manager.InterceptInfo{Id: "C"})
+	failNext = true
+	ctx2, cancel2 := context.WithCancel(dlog.NewTestContext(t, false))
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		n.Checkpoint(ctx2, 10*time.Millisecond, sink)
+	}()
+	assertNextCheckpoint(map[string]*manager.InterceptInfo{
+		"c": {Id: "C"},
+	})
+	assert.False(t, failNext)
+	cancel2()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Checkpoint did not return after the Context was canceled")
+	}
+	n.Close()
+}
+
 func TestInterceptMap_Store(t *testing.T) {
 	// TODO
 }
 
 func TestInterceptMap_CompareAndSwap(t *testing.T) {
-	// TODO
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	// Check that a swap of a missing key fails
+	assert.False(t, m.CompareAndSwap("k", &manager.InterceptInfo{Id: "a"}, &manager.InterceptInfo{Id: "b"}))
+	_, ok := m.Load("k")
+	assert.False(t, ok)
+
+	m.Store("k", &manager.InterceptInfo{Id: "a"})
+	ch := m.Subscribe(ctx)
+	snapshot, ok := <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"k": {Id: "a"},
+			},
+		},
+		snapshot)
+
+	// Check that a swap with a mismatched old value fails, and doesn't notify subscribers
+	assert.False(t, m.CompareAndSwap("k", &manager.InterceptInfo{Id: "x"}, &manager.InterceptInfo{Id: "b"}))
+	v, ok := m.Load("k")
+	assert.True(t, ok)
+	assertDeepCopies(t, &manager.InterceptInfo{Id: "a"}, v)
+	select {
+	case <-ch:
+		t.Error("unexpected snapshot after failed swap")
+	case <-time.After(10 * time.Millisecond): // just long enough that we have confidence <-ch isn't going to happen
+	}
+
+	// Check that a swap with a matching old value succeeds, and notifies subscribers
+	assert.True(t, m.CompareAndSwap("k", &manager.InterceptInfo{Id: "a"}, &manager.InterceptInfo{Id: "b"}))
+	v, ok = m.Load("k")
+	assert.True(t, ok)
+	assertDeepCopies(t, &manager.InterceptInfo{Id: "b"}, v)
+	snapshot, ok = <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"k": {Id: "b"},
+			},
+			Updates: []watchable.InterceptMapUpdate{
+				{Key: "k", Value: &manager.InterceptInfo{Id: "b"}},
+			},
+		},
+		snapshot)
+
+	// Check that the old value is no longer a match
+	assert.False(t, m.CompareAndSwap("k", &manager.InterceptInfo{Id: "a"}, &manager.InterceptInfo{Id: "c"}))
+
+	m.Close()
+}
+
+func TestInterceptMap_SubscriberCount(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	// Check that a zero map has no subscribers
+	assert.Equal(t, 0, m.SubscriberCount())
+
+	ctx1, cancel1 := context.WithCancel(ctx)
+	ch1 := m.Subscribe(ctx1)
+	ctx2, cancel2 := context.WithCancel(ctx)
+	ch2 := m.SubscribeSubset(ctx2, func(string, *manager.InterceptInfo) bool { return true })
+	assert.Equal(t, 2, m.SubscriberCount())
+
+	// Check that ending a subscription removes it
+	cancel1()
+	for range ch1 {
+	}
+	assert.Equal(t, 1, m.SubscriberCount())
+
+	cancel2()
+	for range ch2 {
+	}
+	assert.Equal(t, 0, m.SubscriberCount())
+}
+
+func TestInterceptMap_Update(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	// Check that a missing key isn't updated
+	called := false
+	assert.False(t, m.Update("k", func(v *manager.InterceptInfo) *manager.InterceptInfo {
+		called = true
+		return v
+	}))
+	assert.False(t, called)
+	_, ok := m.Load("k")
+	assert.False(t, ok)
+
+	a := &manager.InterceptInfo{Id: "a"}
+	m.Store("k", a)
+	ch := m.Subscribe(ctx)
+	_, ok = <-ch
+	assert.True(t, ok)
+
+	// Check that the function gets a copy of the current value, and that its result is stored
+	assert.True(t, m.Update("k", func(v *manager.InterceptInfo) *manager.InterceptInfo {
+		assertDeepCopies(t, a, v)
+		v.Id = "b"
+		return v
+	}))
+	assert.Equal(t, "a", a.Id)
+	v, ok := m.Load("k")
+	assert.True(t, ok)
+	assertDeepCopies(t, &manager.InterceptInfo{Id: "b"}, v)
+
+	// Check that subscribers are notified
+	snapshot, ok := <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"k": {Id: "b"},
+			},
+			Updates: []watchable.InterceptMapUpdate{
+				{Key: "k", Value: &manager.InterceptInfo{Id: "b"}},
+			},
+		},
+		snapshot)
+
+	// Check that a closed map isn't updated
+	m.Close()
+	called = false
+	assert.False(t, m.Update("k", func(v *manager.InterceptInfo) *manager.InterceptInfo {
+		called = true
+		return v
+	}))
+	assert.False(t, called)
+	v, ok = m.Load("k")
+	assert.True(t, ok)
+	assertDeepCopies(t, &manager.InterceptInfo{Id: "b"}, v)
 }
 
 func TestInterceptMap_Subscribe(t *testing.T) {
@@ -391,3 +768,323 @@ func TestInterceptMap_SubscribeSubset(t *testing.T) {
 	assert.False(t, ok)
 	assert.Zero(t, snapshot)
 }
+
+func TestInterceptMap_SubscribeWithWatchdog(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+	m.Store("b", &manager.InterceptInfo{Id: "B"})
+
+	// Check that the whole map is subscribed to
+	ch := m.SubscribeWithWatchdog(ctx, watchable.Watchdog{Timeout: 100 * time.Millisecond, Unsubscribe: true})
+	snapshot, ok := <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"a": {Id: "A"},
+				"b": {Id: "B"},
+			},
+		},
+		snapshot)
+
+	// Check that the watchdog closes the channel once a snapshot is left unread
+	m.Store("a", &manager.InterceptInfo{Id: "a"})
+	time.Sleep(500 * time.Millisecond)
+	snapshot, ok = <-ch
+	assert.False(t, ok)
+	assert.Zero(t, snapshot)
+
+	m.Close()
+}
+
+func TestInterceptMap_SubscribeSubsetWithWatchdog(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+	all := func(string, *manager.InterceptInfo) bool { return true }
+
+	// Check that a watchdog that only warns leaves the subscription intact
+	ch := m.SubscribeSubsetWithWatchdog(ctx, all, watchable.Watchdog{Timeout: 100 * time.Millisecond})
+	time.Sleep(500 * time.Millisecond)
+	snapshot, ok := <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"a": {Id: "A"},
+			},
+		},
+		snapshot)
+
+	// Check that a watchdog that unsubscribes closes the channel of a subscriber that doesn't read
+	ch = m.SubscribeSubsetWithWatchdog(ctx, all, watchable.Watchdog{Timeout: 100 * time.Millisecond, Unsubscribe: true})
+	time.Sleep(500 * time.Millisecond)
+	snapshot, ok = <-ch
+	assert.False(t, ok)
+	assert.Zero(t, snapshot)
+
+	// Check that a subscriber that keeps reading isn't unsubscribed
+	ch = m.SubscribeSubsetWithWatchdog(ctx, all, watchable.Watchdog{Timeout: 100 * time.Millisecond, Unsubscribe: true})
+	for i := 0; i < 5; i++ {
+		_, ok = <-ch
+		assert.True(t, ok)
+		time.Sleep(50 * time.Millisecond)
+		m.Store("a", &manager.InterceptInfo{Id: fmt.Sprintf("A%d", i)})
+	}
+	_, ok = <-ch
+	assert.True(t, ok)
+
+	m.Close()
+}
+
+func TestInterceptMap_SetSubsetFilter(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+	m.Store("b", &manager.InterceptInfo{Id: "B"})
+	m.Store("c", &manager.InterceptInfo{Id: "C"})
+
+	ch := m.SubscribeSubset(ctx, func(k string, _ *manager.InterceptInfo) bool {
+		return k != "b"
+	})
+	snapshot, ok := <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"a": {Id: "A"},
+				"c": {Id: "C"},
+			},
+		},
+		snapshot)
+
+	// Check that swapping the predicate re-evaluates the whole map, including entries that
+	// were excluded by the old predicate
+	assert.True(t, m.SetSubsetFilter(ch, func(k string, _ *manager.InterceptInfo) bool {
+		return k != "a"
+	}))
+	snapshot, ok = <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"b": {Id: "B"},
+				"c": {Id: "C"},
+			},
+			Updates: []watchable.InterceptMapUpdate{
+				{Key: "a", Delete: true, Value: &manager.InterceptInfo{Id: "A"}},
+				{Key: "b", Value: &manager.InterceptInfo{Id: "B"}},
+			},
+		},
+		snapshot)
+
+	// Check that the new predicate applies to subsequent writes
+	m.Store("a", &manager.InterceptInfo{Id: "a"})
+	m.Store("b", &manager.InterceptInfo{Id: "b"})
+	snapshot, ok = <-ch
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"b": {Id: "b"},
+				"c": {Id: "C"},
+			},
+			Updates: []watchable.InterceptMapUpdate{
+				{Key: "b", Value: &manager.InterceptInfo{Id: "b"}},
+			},
+		},
+		snapshot)
+
+	// Check that a predicate that includes the same entries doesn't trigger a snapshot
+	assert.True(t, m.SetSubsetFilter(ch, func(k string, _ *manager.InterceptInfo) bool {
+		return k == "b" || k == "c"
+	}))
+	select {
+	case snapshot := <-ch:
+		assert.Failf(t, "unexpected snapshot", "%v", snapshot)
+	case <-time.After(10 * time.Millisecond): // just long enough that we have confidence <-ch isn't going to happen
+	}
+
+	// Check that channels that aren't active subscriptions aren't found
+	var other watchable.InterceptMap
+	assert.False(t, m.SetSubsetFilter(other.Subscribe(ctx), func(string, *manager.InterceptInfo) bool { return true }))
+	other.Close()
+	assert.True(t, m.Unsubscribe(ch))
+	for range ch {
+	}
+	assert.False(t, m.SetSubsetFilter(ch, func(string, *manager.InterceptInfo) bool { return true }))
+
+	m.Close()
+}
+
+func TestInterceptMap_Unsubscribe(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+
+	ch1 := m.Subscribe(ctx)
+	ch2 := m.Subscribe(ctx)
+	_, ok := <-ch1
+	assert.True(t, ok)
+	assert.Equal(t, 2, m.SubscriberCount())
+
+	// Check that unsubscribing closes the channel, even with an unread snapshot pending
+	m.Store("a", &manager.InterceptInfo{Id: "a"})
+	assert.True(t, m.Unsubscribe(ch1))
+	assert.Equal(t, 1, m.SubscriberCount())
+	for range ch1 {
+	}
+	snapshot, ok := <-ch1
+	assert.False(t, ok)
+	assert.Zero(t, snapshot)
+
+	// Check that a repeated unsubscribe reports that the subscription wasn't found
+	assert.False(t, m.Unsubscribe(ch1))
+
+	// Check that a channel that didn't come from this map isn't found
+	var other watchable.InterceptMap
+	assert.False(t, m.Unsubscribe(other.Subscribe(ctx)))
+	other.Close()
+
+	// Check that a subscription that ended with its context isn't found
+	ctx3, cancel3 := context.WithCancel(ctx)
+	ch3 := m.Subscribe(ctx3)
+	cancel3()
+	for range ch3 {
+	}
+	assert.False(t, m.Unsubscribe(ch3))
+
+	// Check that the other subscription is unaffected
+	snapshot, ok = <-ch2
+	assert.True(t, ok)
+	assertInterceptMapSnapshotEqual(t,
+		watchable.InterceptMapSnapshot{
+			State: map[string]*manager.InterceptInfo{
+				"a": {Id: "a"},
+			},
+			Updates: []watchable.InterceptMapUpdate{
+				{Key: "a", Value: &manager.InterceptInfo{Id: "a"}},
+			},
+		},
+		snapshot)
+	assert.True(t, m.Unsubscribe(ch2))
+	for range ch2 {
+	}
+	assert.Equal(t, 0, m.SubscriberCount())
+
+	// Check that Close doesn't wait for any leftover goroutines
+	m.Close()
+}
+
+// TestInterceptMap_SubscribeConcurrentStore checks that subscribing never deadlocks with a .Store()
+// that happens while the subscription is being set up, and that the subscription's filter can be
+// replaced as soon as .Subscribe() returns.
+func TestInterceptMap_SubscribeConcurrentStore(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	var m watchable.InterceptMap
+
+	const n = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		wg.Add(2 * n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+				ch := m.Subscribe(ctx)
+				assert.True(t, m.SetSubsetFilter(ch, func(string, *manager.InterceptInfo) bool { return false }))
+			}()
+			go func(i int) {
+				defer wg.Done()
+				m.Store(fmt.Sprintf("k%d", i), &manager.InterceptInfo{Id: "v"})
+			}(i)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock: concurrent Subscribe and Store did not complete")
+	}
+	assert.Equal(t, n, m.SubscriberCount())
+	m.Close()
+}
+
+func TestInterceptMap_SubscribeDeltas(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	ctx, cancelCtx := context.WithCancel(ctx)
+	var m watchable.InterceptMap
+
+	m.Store("b", &manager.InterceptInfo{Id: "B"})
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+
+	ch := m.SubscribeDeltas(ctx)
+
+	assertUpdate := func(expected watchable.InterceptMapUpdate) {
+		t.Helper()
+		actual, ok := <-ch
+		assert.True(t, ok)
+		assert.Equal(t, expected.Key, actual.Key)
+		assert.Equal(t, expected.Delete, actual.Delete)
+		assertDeepCopies(t, expected.Value, actual.Value)
+	}
+	assertNoUpdate := func() {
+		t.Helper()
+		select {
+		case update := <-ch:
+			t.Errorf("unexpected update: %v", update)
+		case <-time.After(10 * time.Millisecond): // just long enough that we have confidence <-ch isn't going to happen
+		}
+	}
+
+	// Check that the initial contents are delivered as updates, ordered by key
+	assertUpdate(watchable.InterceptMapUpdate{Key: "a", Value: &manager.InterceptInfo{Id: "A"}})
+	assertUpdate(watchable.InterceptMapUpdate{Key: "b", Value: &manager.InterceptInfo{Id: "B"}})
+	assertNoUpdate()
+
+	// Check that a no-op write doesn't trigger an update
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+	assertNoUpdate()
+
+	// Check that multiple writes to the same key get coalesced in to the latest one
+	m.Store("c", &manager.InterceptInfo{Id: "C"})
+	m.Store("c", &manager.InterceptInfo{Id: "c"})
+	m.Store("a", &manager.InterceptInfo{Id: "a"})
+	assertUpdate(watchable.InterceptMapUpdate{Key: "c", Value: &manager.InterceptInfo{Id: "c"}})
+	assertUpdate(watchable.InterceptMapUpdate{Key: "a", Value: &manager.InterceptInfo{Id: "a"}})
+	assertNoUpdate()
+
+	// Check that deletes work
+	m.Delete("b")
+	assertUpdate(watchable.InterceptMapUpdate{Key: "b", Delete: true, Value: &manager.InterceptInfo{Id: "B"}})
+
+	// Check that changes that cancel each other out aren't delivered at all
+	m.Store("d", &manager.InterceptInfo{Id: "D"})
+	m.Delete("d")
+	m.Store("a", &manager.InterceptInfo{Id: "A"})
+	m.Store("a", &manager.InterceptInfo{Id: "a"})
+	assertNoUpdate()
+
+	// Check that the channel gets closed when the context is canceled
+	cancelCtx()
+	// Because the 'close' happens asynchronously when the context ends, we need to wait a
+	// moment to ensure that it's actually closed before we hit the next step.
+	time.Sleep(500 * time.Millisecond)
+	update, ok := <-ch
+	assert.False(t, ok)
+	assert.Zero(t, update)
+
+	// Check that subscriptions on a closed map get already-closed channels
+	m.Close()
+	ch = m.SubscribeDeltas(dlog.NewTestContext(t, true))
+	update, ok = <-ch
+	assert.False(t, ok)
+	assert.Zero(t, update)
+}
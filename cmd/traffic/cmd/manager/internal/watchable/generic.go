@@ -2,4 +2,30 @@
 //go:generate ./generic.gen AgentMap     *github.com/telepresenceio/telepresence/rpc/v2/manager.AgentInfo     Name
 //go:generate ./generic.gen ClientMap    *github.com/telepresenceio/telepresence/rpc/v2/manager.ClientInfo    Name
 
+// Package watchable provides thread-safe maps of protobuf messages that can be subscribed to.
+//
+// The map types are generated from generic.tmpl.go, and their tests from generic_test.tmpl.go, by
+// the generic.gen script.  To add a map for a new message type, add a go:generate line above:
+//
+//	//go:generate ./generic.gen MAPTYPE *IMPORTPATH.VALTYPE TESTFIELD
+//
+// MAPTYPE is the name of the new map type.  VALTYPE must be a protobuf message, because values are
+// deepcopied with proto.Clone.  TESTFIELD is a string field of VALTYPE that the generated tests
+// can set and compare.  Then run "go generate" in this directory (or "make generate" in the
+// repository root) to emit generated_<maptype>.go and generated_<maptype>_test.go.
 package watchable
+
+import (
+	"time"
+)
+
+// Watchdog detects subscribers that don't read from their subscription channel.
+type Watchdog struct {
+	// Timeout is how long a snapshot may be left unread before the watchdog fires.  Zero disables
+	// the watchdog.
+	Timeout time.Duration
+
+	// Unsubscribe makes the watchdog end the subscription, closing its channel, when it fires.
+	// Otherwise, the watchdog only logs a warning.
+	Unsubscribe bool
+}
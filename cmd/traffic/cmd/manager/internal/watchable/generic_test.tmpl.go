@@ -6,6 +6,8 @@ package watchable_test
 import (
     "context"
     "encoding/json"
+    "fmt"
+    "sync"
     "testing"
     "time"
 
@@ -51,7 +53,64 @@ func assertMAPTYPESnapshotEqual(t *testing.T, expected, actual watchable.MAPTYPE
 }
 
 func TestMAPTYPE_Close(t *testing.T) {
-    // TODO
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    // Check that a zero map isn't closed
+    assert.False(t, m.IsClosed())
+
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+    ch := m.Subscribe(ctx)
+    m.Close()
+    assert.True(t, m.IsClosed())
+
+    // Check that subscriptions get closed
+    for range ch {
+    }
+
+    // Check that Store is a no-op
+    m.Store("b", VALCTOR{TESTFIELD: "B"})
+    m.Store("a", VALCTOR{TESTFIELD: "a"})
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"a": {TESTFIELD: "A"},
+	    },
+	},
+	watchable.MAPTYPESnapshot{State: m.LoadAll()})
+
+    // Check that Delete is a no-op
+    m.Delete("a")
+    v, ok := m.Load("a")
+    assert.True(t, ok)
+    assertDeepCopies(t, VALCTOR{TESTFIELD: "A"}, v)
+
+    // Check that LoadOrStore loads, but doesn't store
+    v, ok = m.LoadOrStore("a", VALCTOR{TESTFIELD: "a"})
+    assert.True(t, ok)
+    assertDeepCopies(t, VALCTOR{TESTFIELD: "A"}, v)
+    v, ok = m.LoadOrStore("c", VALCTOR{TESTFIELD: "C"})
+    assert.False(t, ok)
+    assert.Nil(t, v)
+    _, ok = m.Load("c")
+    assert.False(t, ok)
+
+    // Check that CompareAndSwap doesn't swap
+    assert.False(t, m.CompareAndSwap("a", VALCTOR{TESTFIELD: "A"}, VALCTOR{TESTFIELD: "a"}))
+    v, ok = m.Load("a")
+    assert.True(t, ok)
+    assertDeepCopies(t, VALCTOR{TESTFIELD: "A"}, v)
+
+    // Check that LoadAndDelete doesn't delete
+    v, ok = m.LoadAndDelete("a")
+    assert.False(t, ok)
+    assert.Nil(t, v)
+    _, ok = m.Load("a")
+    assert.True(t, ok)
+
+    // Check that a repeated Close works
+    m.Close()
+    assert.True(t, m.IsClosed())
 }
 
 func TestMAPTYPE_Delete(t *testing.T) {
@@ -120,6 +179,58 @@ func TestMAPTYPE_LoadAll(t *testing.T) {
     // TODO
 }
 
+func TestMAPTYPE_LoadAllMatching(t *testing.T) {
+    var m watchable.MAPTYPE
+
+    // Check that a zero map works
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{State: map[string]VALTYPE{}},
+	watchable.MAPTYPESnapshot{State: m.LoadAllMatching(func(string, VALTYPE) bool { return true })})
+
+    a := VALCTOR{TESTFIELD: "A"}
+    m.Store("a", a)
+    m.Store("b", VALCTOR{TESTFIELD: "B"})
+    m.Store("c", VALCTOR{TESTFIELD: "ignoreme"})
+
+    // Check that only the entries that satisfy the predicate are returned
+    matching := m.LoadAllMatching(func(k string, v VALTYPE) bool {
+	return v.TESTFIELD != "ignoreme"
+    })
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"a": {TESTFIELD: "A"},
+		"b": {TESTFIELD: "B"},
+	    },
+	},
+	watchable.MAPTYPESnapshot{State: matching})
+
+    // Check that the returned values are copies
+    assertDeepCopies(t, a, matching["a"])
+
+    // Check that the predicate also gets passed the key
+    matching = m.LoadAllMatching(func(k string, v VALTYPE) bool {
+	return k == "c"
+    })
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"c": {TESTFIELD: "ignoreme"},
+	    },
+	},
+	watchable.MAPTYPESnapshot{State: matching})
+
+    // Check that a predicate that mutates its argument doesn't affect the map
+    before := m.LoadAll()
+    m.LoadAllMatching(func(_ string, v VALTYPE) bool {
+	v.TESTFIELD = "mutated"
+	return false
+    })
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{State: before},
+	watchable.MAPTYPESnapshot{State: m.LoadAll()})
+}
+
 func TestMAPTYPE_LoadAndDelete(t *testing.T) {
     var m watchable.MAPTYPE
 
@@ -170,12 +281,278 @@ func TestMAPTYPE_LoadOrStore(t *testing.T) {
     assertDeepCopies(t, d, e)
 }
 
+func TestMAPTYPE_SnapshotRestore(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+    m.Store("b", VALCTOR{TESTFIELD: "B"})
+    data, err := m.Snapshot()
+    assert.NoError(t, err)
+
+    // Check that a restored map contains the same entries, as copies
+    var r watchable.MAPTYPE
+    r.Store("c", VALCTOR{TESTFIELD: "C"})
+    assert.NoError(t, r.Restore(data))
+    expected := watchable.MAPTYPESnapshot{
+	State: map[string]VALTYPE{
+	    "a": {TESTFIELD: "A"},
+	    "b": {TESTFIELD: "B"},
+	    "c": {TESTFIELD: "C"},
+	},
+    }
+    assertMAPTYPESnapshotEqual(t, expected, watchable.MAPTYPESnapshot{State: r.LoadAll()})
+
+    // Check that the restored entries are part of the initial snapshot
+    ch := r.Subscribe(ctx)
+    snapshot, ok := <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t, expected, snapshot)
+
+    // Check that undecodable data is rejected without storing anything
+    assert.Error(t, r.Restore([]byte(`{"d": {}, "e": 1}`)))
+    assert.Error(t, r.Restore([]byte(`garbage`)))
+    assertMAPTYPESnapshotEqual(t, expected, watchable.MAPTYPESnapshot{State: r.LoadAll()})
+
+    // Check that an empty map round-trips
+    var e watchable.MAPTYPE
+    data, err = e.Snapshot()
+    assert.NoError(t, err)
+    assert.NoError(t, r.Restore(data))
+    assertMAPTYPESnapshotEqual(t, expected, watchable.MAPTYPESnapshot{State: r.LoadAll()})
+
+    r.Close()
+}
+
+func TestMAPTYPE_Checkpoint(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+
+    checkpoints := make(chan []byte, 10)
+    failNext := false
+    sink := func(data []byte) error {
+	if failNext {
+	    failNext = false
+	    return fmt.Errorf("unable to write checkpoint")
+	}
+	checkpoints <- data
+	return nil
+    }
+    assertNextCheckpoint := func(expected map[string]VALTYPE) {
+	t.Helper()
+	select {
+	case data := <-checkpoints:
+	    var r watchable.MAPTYPE
+	    assert.NoError(t, r.Restore(data))
+	    assertMAPTYPESnapshotEqual(t, watchable.MAPTYPESnapshot{State: expected}, watchable.MAPTYPESnapshot{State: r.LoadAll()})
+	case <-time.After(time.Second):
+	    t.Fatal("timed out waiting for checkpoint")
+	}
+    }
+    assertNoCheckpoint := func() {
+	t.Helper()
+	select {
+	case data := <-checkpoints:
+	    assert.Failf(t, "unexpected checkpoint", "%s", data)
+	case <-time.After(50 * time.Millisecond): // a handful of intervals
+	}
+    }
+
+    done := make(chan struct{})
+    go func() {
+	defer close(done)
+	m.Checkpoint(ctx, 10*time.Millisecond, sink)
+    }()
+
+    // Check that the first tick writes a checkpoint, and that an unchanged map doesn't
+    assertNextCheckpoint(map[string]VALTYPE{
+	"a": {TESTFIELD: "A"},
+    })
+    assertNoCheckpoint()
+
+    // Check that changes are written on the next tick
+    m.Store("b", VALCTOR{TESTFIELD: "B"})
+    assertNextCheckpoint(map[string]VALTYPE{
+	"a": {TESTFIELD: "A"},
+	"b": {TESTFIELD: "B"},
+    })
+
+    // Check that Close makes a final checkpoint and ends the checkpointing
+    m.Close()
+    select {
+    case <-done:
+    case <-time.After(time.Second):
+	t.Fatal("Checkpoint did not return after Close")
+    }
+    assertNoCheckpoint()
+
+    // Check that a failed checkpoint is retried, and that canceling the Context ends the
+    // checkpointing.  The failure is logged as an error, so use a Context that permits that.
+    var n watchable.MAPTYPE
+    n.Store("c", VALCTOR{TESTFIELD: "C"})
+    failNext = true
+    ctx2, cancel2 := context.WithCancel(dlog.NewTestContext(t, false))
+    done = make(chan struct{})
+    go func() {
+	defer close(done)
+	n.Checkpoint(ctx2, 10*time.Millisecond, sink)
+    }()
+    assertNextCheckpoint(map[string]VALTYPE{
+	"c": {TESTFIELD: "C"},
+    })
+    assert.False(t, failNext)
+    cancel2()
+    select {
+    case <-done:
+    case <-time.After(time.Second):
+	t.Fatal("Checkpoint did not return after the Context was canceled")
+    }
+    n.Close()
+}
+
 func TestMAPTYPE_Store(t *testing.T) {
     // TODO
 }
 
 func TestMAPTYPE_CompareAndSwap(t *testing.T) {
-    // TODO
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    // Check that a swap of a missing key fails
+    assert.False(t, m.CompareAndSwap("k", VALCTOR{TESTFIELD: "a"}, VALCTOR{TESTFIELD: "b"}))
+    _, ok := m.Load("k")
+    assert.False(t, ok)
+
+    m.Store("k", VALCTOR{TESTFIELD: "a"})
+    ch := m.Subscribe(ctx)
+    snapshot, ok := <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"k": {TESTFIELD: "a"},
+	    },
+	},
+	snapshot)
+
+    // Check that a swap with a mismatched old value fails, and doesn't notify subscribers
+    assert.False(t, m.CompareAndSwap("k", VALCTOR{TESTFIELD: "x"}, VALCTOR{TESTFIELD: "b"}))
+    v, ok := m.Load("k")
+    assert.True(t, ok)
+    assertDeepCopies(t, VALCTOR{TESTFIELD: "a"}, v)
+    select {
+    case <-ch:
+	t.Error("unexpected snapshot after failed swap")
+    case <-time.After(10 * time.Millisecond): // just long enough that we have confidence <-ch isn't going to happen
+    }
+
+    // Check that a swap with a matching old value succeeds, and notifies subscribers
+    assert.True(t, m.CompareAndSwap("k", VALCTOR{TESTFIELD: "a"}, VALCTOR{TESTFIELD: "b"}))
+    v, ok = m.Load("k")
+    assert.True(t, ok)
+    assertDeepCopies(t, VALCTOR{TESTFIELD: "b"}, v)
+    snapshot, ok = <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"k": {TESTFIELD: "b"},
+	    },
+	    Updates: []watchable.MAPTYPEUpdate{
+		{Key: "k", Value: VALCTOR{TESTFIELD: "b"}},
+	    },
+	},
+	snapshot)
+
+    // Check that the old value is no longer a match
+    assert.False(t, m.CompareAndSwap("k", VALCTOR{TESTFIELD: "a"}, VALCTOR{TESTFIELD: "c"}))
+
+    m.Close()
+}
+
+func TestMAPTYPE_SubscriberCount(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    // Check that a zero map has no subscribers
+    assert.Equal(t, 0, m.SubscriberCount())
+
+    ctx1, cancel1 := context.WithCancel(ctx)
+    ch1 := m.Subscribe(ctx1)
+    ctx2, cancel2 := context.WithCancel(ctx)
+    ch2 := m.SubscribeSubset(ctx2, func(string, VALTYPE) bool { return true })
+    assert.Equal(t, 2, m.SubscriberCount())
+
+    // Check that ending a subscription removes it
+    cancel1()
+    for range ch1 {
+    }
+    assert.Equal(t, 1, m.SubscriberCount())
+
+    cancel2()
+    for range ch2 {
+    }
+    assert.Equal(t, 0, m.SubscriberCount())
+}
+
+func TestMAPTYPE_Update(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    // Check that a missing key isn't updated
+    called := false
+    assert.False(t, m.Update("k", func(v VALTYPE) VALTYPE {
+	called = true
+	return v
+    }))
+    assert.False(t, called)
+    _, ok := m.Load("k")
+    assert.False(t, ok)
+
+    a := VALCTOR{TESTFIELD: "a"}
+    m.Store("k", a)
+    ch := m.Subscribe(ctx)
+    _, ok = <-ch
+    assert.True(t, ok)
+
+    // Check that the function gets a copy of the current value, and that its result is stored
+    assert.True(t, m.Update("k", func(v VALTYPE) VALTYPE {
+	assertDeepCopies(t, a, v)
+	v.TESTFIELD = "b"
+	return v
+    }))
+    assert.Equal(t, "a", a.TESTFIELD)
+    v, ok := m.Load("k")
+    assert.True(t, ok)
+    assertDeepCopies(t, VALCTOR{TESTFIELD: "b"}, v)
+
+    // Check that subscribers are notified
+    snapshot, ok := <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"k": {TESTFIELD: "b"},
+	    },
+	    Updates: []watchable.MAPTYPEUpdate{
+		{Key: "k", Value: VALCTOR{TESTFIELD: "b"}},
+	    },
+	},
+	snapshot)
+
+    // Check that a closed map isn't updated
+    m.Close()
+    called = false
+    assert.False(t, m.Update("k", func(v VALTYPE) VALTYPE {
+	called = true
+	return v
+    }))
+    assert.False(t, called)
+    v, ok = m.Load("k")
+    assert.True(t, ok)
+    assertDeepCopies(t, VALCTOR{TESTFIELD: "b"}, v)
 }
 
 func TestMAPTYPE_Subscribe(t *testing.T) {
@@ -392,3 +769,323 @@ func TestMAPTYPE_SubscribeSubset(t *testing.T) {
     assert.False(t, ok)
     assert.Zero(t, snapshot)
 }
+
+func TestMAPTYPE_SubscribeWithWatchdog(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+    m.Store("b", VALCTOR{TESTFIELD: "B"})
+
+    // Check that the whole map is subscribed to
+    ch := m.SubscribeWithWatchdog(ctx, watchable.Watchdog{Timeout: 100 * time.Millisecond, Unsubscribe: true})
+    snapshot, ok := <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"a": {TESTFIELD: "A"},
+		"b": {TESTFIELD: "B"},
+	    },
+	},
+	snapshot)
+
+    // Check that the watchdog closes the channel once a snapshot is left unread
+    m.Store("a", VALCTOR{TESTFIELD: "a"})
+    time.Sleep(500 * time.Millisecond)
+    snapshot, ok = <-ch
+    assert.False(t, ok)
+    assert.Zero(t, snapshot)
+
+    m.Close()
+}
+
+func TestMAPTYPE_SubscribeSubsetWithWatchdog(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+    all := func(string, VALTYPE) bool { return true }
+
+    // Check that a watchdog that only warns leaves the subscription intact
+    ch := m.SubscribeSubsetWithWatchdog(ctx, all, watchable.Watchdog{Timeout: 100 * time.Millisecond})
+    time.Sleep(500 * time.Millisecond)
+    snapshot, ok := <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"a": {TESTFIELD: "A"},
+	    },
+	},
+	snapshot)
+
+    // Check that a watchdog that unsubscribes closes the channel of a subscriber that doesn't read
+    ch = m.SubscribeSubsetWithWatchdog(ctx, all, watchable.Watchdog{Timeout: 100 * time.Millisecond, Unsubscribe: true})
+    time.Sleep(500 * time.Millisecond)
+    snapshot, ok = <-ch
+    assert.False(t, ok)
+    assert.Zero(t, snapshot)
+
+    // Check that a subscriber that keeps reading isn't unsubscribed
+    ch = m.SubscribeSubsetWithWatchdog(ctx, all, watchable.Watchdog{Timeout: 100 * time.Millisecond, Unsubscribe: true})
+    for i := 0; i < 5; i++ {
+	_, ok = <-ch
+	assert.True(t, ok)
+	time.Sleep(50 * time.Millisecond)
+	m.Store("a", VALCTOR{TESTFIELD: fmt.Sprintf("A%d", i)})
+    }
+    _, ok = <-ch
+    assert.True(t, ok)
+
+    m.Close()
+}
+
+func TestMAPTYPE_SetSubsetFilter(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+    m.Store("b", VALCTOR{TESTFIELD: "B"})
+    m.Store("c", VALCTOR{TESTFIELD: "C"})
+
+    ch := m.SubscribeSubset(ctx, func(k string, _ VALTYPE) bool {
+	return k != "b"
+    })
+    snapshot, ok := <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"a": {TESTFIELD: "A"},
+		"c": {TESTFIELD: "C"},
+	    },
+	},
+	snapshot)
+
+    // Check that swapping the predicate re-evaluates the whole map, including entries that
+    // were excluded by the old predicate
+    assert.True(t, m.SetSubsetFilter(ch, func(k string, _ VALTYPE) bool {
+	return k != "a"
+    }))
+    snapshot, ok = <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"b": {TESTFIELD: "B"},
+		"c": {TESTFIELD: "C"},
+	    },
+	    Updates: []watchable.MAPTYPEUpdate{
+		{Key: "a", Delete: true, Value: VALCTOR{TESTFIELD: "A"}},
+		{Key: "b", Value: VALCTOR{TESTFIELD: "B"}},
+	    },
+	},
+	snapshot)
+
+    // Check that the new predicate applies to subsequent writes
+    m.Store("a", VALCTOR{TESTFIELD: "a"})
+    m.Store("b", VALCTOR{TESTFIELD: "b"})
+    snapshot, ok = <-ch
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"b": {TESTFIELD: "b"},
+		"c": {TESTFIELD: "C"},
+	    },
+	    Updates: []watchable.MAPTYPEUpdate{
+		{Key: "b", Value: VALCTOR{TESTFIELD: "b"}},
+	    },
+	},
+	snapshot)
+
+    // Check that a predicate that includes the same entries doesn't trigger a snapshot
+    assert.True(t, m.SetSubsetFilter(ch, func(k string, _ VALTYPE) bool {
+	return k == "b" || k == "c"
+    }))
+    select {
+    case snapshot := <-ch:
+	assert.Failf(t, "unexpected snapshot", "%v", snapshot)
+    case <-time.After(10 * time.Millisecond): // just long enough that we have confidence <-ch isn't going to happen
+    }
+
+    // Check that channels that aren't active subscriptions aren't found
+    var other watchable.MAPTYPE
+    assert.False(t, m.SetSubsetFilter(other.Subscribe(ctx), func(string, VALTYPE) bool { return true }))
+    other.Close()
+    assert.True(t, m.Unsubscribe(ch))
+    for range ch {
+    }
+    assert.False(t, m.SetSubsetFilter(ch, func(string, VALTYPE) bool { return true }))
+
+    m.Close()
+}
+
+func TestMAPTYPE_Unsubscribe(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+
+    ch1 := m.Subscribe(ctx)
+    ch2 := m.Subscribe(ctx)
+    _, ok := <-ch1
+    assert.True(t, ok)
+    assert.Equal(t, 2, m.SubscriberCount())
+
+    // Check that unsubscribing closes the channel, even with an unread snapshot pending
+    m.Store("a", VALCTOR{TESTFIELD: "a"})
+    assert.True(t, m.Unsubscribe(ch1))
+    assert.Equal(t, 1, m.SubscriberCount())
+    for range ch1 {
+    }
+    snapshot, ok := <-ch1
+    assert.False(t, ok)
+    assert.Zero(t, snapshot)
+
+    // Check that a repeated unsubscribe reports that the subscription wasn't found
+    assert.False(t, m.Unsubscribe(ch1))
+
+    // Check that a channel that didn't come from this map isn't found
+    var other watchable.MAPTYPE
+    assert.False(t, m.Unsubscribe(other.Subscribe(ctx)))
+    other.Close()
+
+    // Check that a subscription that ended with its context isn't found
+    ctx3, cancel3 := context.WithCancel(ctx)
+    ch3 := m.Subscribe(ctx3)
+    cancel3()
+    for range ch3 {
+    }
+    assert.False(t, m.Unsubscribe(ch3))
+
+    // Check that the other subscription is unaffected
+    snapshot, ok = <-ch2
+    assert.True(t, ok)
+    assertMAPTYPESnapshotEqual(t,
+	watchable.MAPTYPESnapshot{
+	    State: map[string]VALTYPE{
+		"a": {TESTFIELD: "a"},
+	    },
+	    Updates: []watchable.MAPTYPEUpdate{
+		{Key: "a", Value: VALCTOR{TESTFIELD: "a"}},
+	    },
+	},
+	snapshot)
+    assert.True(t, m.Unsubscribe(ch2))
+    for range ch2 {
+    }
+    assert.Equal(t, 0, m.SubscriberCount())
+
+    // Check that Close doesn't wait for any leftover goroutines
+    m.Close()
+}
+
+// TestMAPTYPE_SubscribeConcurrentStore checks that subscribing never deadlocks with a .Store()
+// that happens while the subscription is being set up, and that the subscription's filter can be
+// replaced as soon as .Subscribe() returns.
+func TestMAPTYPE_SubscribeConcurrentStore(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    var m watchable.MAPTYPE
+
+    const n = 200
+    done := make(chan struct{})
+    go func() {
+	defer close(done)
+	var wg sync.WaitGroup
+	wg.Add(2 * n)
+	for i := 0; i < n; i++ {
+	    go func() {
+		defer wg.Done()
+		ch := m.Subscribe(ctx)
+		assert.True(t, m.SetSubsetFilter(ch, func(string, VALTYPE) bool { return false }))
+	    }()
+	    go func(i int) {
+		defer wg.Done()
+		m.Store(fmt.Sprintf("k%d", i), VALCTOR{TESTFIELD: "v"})
+	    }(i)
+	}
+	wg.Wait()
+    }()
+
+    select {
+    case <-done:
+    case <-time.After(10 * time.Second):
+	t.Fatal("deadlock: concurrent Subscribe and Store did not complete")
+    }
+    assert.Equal(t, n, m.SubscriberCount())
+    m.Close()
+}
+
+func TestMAPTYPE_SubscribeDeltas(t *testing.T) {
+    ctx := dlog.NewTestContext(t, true)
+    ctx, cancelCtx := context.WithCancel(ctx)
+    var m watchable.MAPTYPE
+
+    m.Store("b", VALCTOR{TESTFIELD: "B"})
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+
+    ch := m.SubscribeDeltas(ctx)
+
+    assertUpdate := func(expected watchable.MAPTYPEUpdate) {
+	t.Helper()
+	actual, ok := <-ch
+	assert.True(t, ok)
+	assert.Equal(t, expected.Key, actual.Key)
+	assert.Equal(t, expected.Delete, actual.Delete)
+	assertDeepCopies(t, expected.Value, actual.Value)
+    }
+    assertNoUpdate := func() {
+	t.Helper()
+	select {
+	case update := <-ch:
+	    t.Errorf("unexpected update: %v", update)
+	case <-time.After(10 * time.Millisecond): // just long enough that we have confidence <-ch isn't going to happen
+	}
+    }
+
+    // Check that the initial contents are delivered as updates, ordered by key
+    assertUpdate(watchable.MAPTYPEUpdate{Key: "a", Value: VALCTOR{TESTFIELD: "A"}})
+    assertUpdate(watchable.MAPTYPEUpdate{Key: "b", Value: VALCTOR{TESTFIELD: "B"}})
+    assertNoUpdate()
+
+    // Check that a no-op write doesn't trigger an update
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+    assertNoUpdate()
+
+    // Check that multiple writes to the same key get coalesced in to the latest one
+    m.Store("c", VALCTOR{TESTFIELD: "C"})
+    m.Store("c", VALCTOR{TESTFIELD: "c"})
+    m.Store("a", VALCTOR{TESTFIELD: "a"})
+    assertUpdate(watchable.MAPTYPEUpdate{Key: "c", Value: VALCTOR{TESTFIELD: "c"}})
+    assertUpdate(watchable.MAPTYPEUpdate{Key: "a", Value: VALCTOR{TESTFIELD: "a"}})
+    assertNoUpdate()
+
+    // Check that deletes work
+    m.Delete("b")
+    assertUpdate(watchable.MAPTYPEUpdate{Key: "b", Delete: true, Value: VALCTOR{TESTFIELD: "B"}})
+
+    // Check that changes that cancel each other out aren't delivered at all
+    m.Store("d", VALCTOR{TESTFIELD: "D"})
+    m.Delete("d")
+    m.Store("a", VALCTOR{TESTFIELD: "A"})
+    m.Store("a", VALCTOR{TESTFIELD: "a"})
+    assertNoUpdate()
+
+    // Check that the channel gets closed when the context is canceled
+    cancelCtx()
+    // Because the 'close' happens asynchronously when the context ends, we need to wait a
+    // moment to ensure that it's actually closed before we hit the next step.
+    time.Sleep(500 * time.Millisecond)
+    update, ok := <-ch
+    assert.False(t, ok)
+    assert.Zero(t, update)
+
+    // Check that subscriptions on a closed map get already-closed channels
+    m.Close()
+    ch = m.SubscribeDeltas(dlog.NewTestContext(t, true))
+    update, ok = <-ch
+    assert.False(t, ok)
+    assert.Zero(t, update)
+}
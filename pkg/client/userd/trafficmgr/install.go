@@ -147,7 +147,7 @@ func (ki *installer) rolloutRestart(c context.Context, obj k8sapi.Object) error
 	restartAnnotation := fmt.Sprintf(
 		`{"spec": {"template": {"metadata": {"annotations": {"%srestartedAt": "%s"}}}}}`,
 		install.DomainPrefix,
-		time.Now().Format(time.RFC3339),
+		time.Now().Format(time.RFC3339Nano),
 	)
 	return obj.Patch(c, types.StrategicMergePatchType, []byte(restartAnnotation))
 }